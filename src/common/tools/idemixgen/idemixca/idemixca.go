@@ -0,0 +1,160 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package idemixca implements the credential-generation logic used by the
+// idemixgen command line tool: creating issuer (CA) key material, the
+// long-term revocation key, and signer configs for individual identities.
+package idemixca
+
+import (
+	"math/big"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/foglink/fnkcore/src/idemix"
+	"github.com/foglink/fnkcore/src/msp"
+	"github.com/pkg/errors"
+)
+
+// issuerAttributeNames are the attributes bound into every credential issued
+// against an issuer key: org unit and role for MSP policy evaluation,
+// enrollment id and revocation handle for auditability and revocation.
+var issuerAttributeNames = []string{
+	msp.AttributeNameOU,
+	msp.AttributeNameRole,
+	msp.AttributeNameEnrollmentId,
+	msp.AttributeNameRevocationHandle,
+}
+
+// GenerateIssuerKey creates a new issuer (CA) key pair for the Idemix MSP.
+func GenerateIssuerKey() ([]byte, []byte, error) {
+	rng, err := idemix.GetRand()
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed creating RNG")
+	}
+
+	key, err := idemix.NewIssuerKey(issuerAttributeNames, rng)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed creating issuer key")
+	}
+
+	ipkBytes, err := proto.Marshal(key.Ipk)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed marshalling issuer public key")
+	}
+
+	return key.Isk, ipkBytes, nil
+}
+
+// GenerateLongTermRevocationKey creates a new ECDSA key pair that the CA uses
+// to sign CredentialRevocationInformation.
+func GenerateLongTermRevocationKey() (*idemix.RevocationKey, error) {
+	key, err := idemix.GenerateLongTermRevocationKey()
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed creating revocation key")
+	}
+	return key, nil
+}
+
+// GenerateCRI creates a CredentialRevocationInformation, signed with revocationKey,
+// that attests that none of revokedHandles are valid anymore.
+func GenerateCRI(revocationKey *idemix.RevocationKey, revokedHandles []string) ([]byte, error) {
+	rng, err := idemix.GetRand()
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed creating RNG")
+	}
+
+	handles := make([]*big.Int, len(revokedHandles))
+	for i, h := range revokedHandles {
+		handle, ok := new(big.Int).SetString(h, 10)
+		if !ok {
+			return nil, errors.Errorf("revocation handle %q is not a valid integer", h)
+		}
+		handles[i] = handle
+	}
+
+	cri, err := idemix.CreateCRI(revocationKey, handles, idemix.AlgNoRevocation, rng)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed creating CRI")
+	}
+
+	criBytes, err := proto.Marshal(cri)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed marshalling CRI")
+	}
+
+	return criBytes, nil
+}
+
+// GenerateRevocationHandle creates a new, random revocation handle to be
+// bound into a signer's credential.
+func GenerateRevocationHandle() (string, error) {
+	rng, err := idemix.GetRand()
+	if err != nil {
+		return "", errors.WithMessage(err, "failed creating RNG")
+	}
+	return idemix.RandModOrder(rng).String(), nil
+}
+
+// GenerateSignerConfig creates a new signer config for the given issuer key.
+// enrollmentId and revocationHandle are bound as additional attributes into
+// the issued credential, so the signer can be audited and revoked.
+func GenerateSignerConfig(isAdmin bool, ou, enrollmentId, revocationHandle string, key *idemix.IssuerKey, revocationPublicKey []byte) ([]byte, error) {
+	rng, err := idemix.GetRand()
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed creating RNG")
+	}
+
+	sk := idemix.RandModOrder(rng)
+	ipk := key.Ipk
+
+	role := msp.MEMBER
+	if isAdmin {
+		role = role | msp.ADMIN
+	}
+
+	attrs := make([]*big.Int, len(ipk.AttributeNames))
+	for i, name := range ipk.AttributeNames {
+		switch name {
+		case msp.AttributeNameOU:
+			attrs[i] = idemix.HashModOrder([]byte(ou))
+		case msp.AttributeNameRole:
+			attrs[i] = big.NewInt(int64(role))
+		case msp.AttributeNameEnrollmentId:
+			attrs[i] = idemix.HashModOrder([]byte(enrollmentId))
+		case msp.AttributeNameRevocationHandle:
+			handle, ok := new(big.Int).SetString(revocationHandle, 10)
+			if !ok {
+				return nil, errors.Errorf("revocation handle %q is not a valid integer", revocationHandle)
+			}
+			attrs[i] = handle
+		}
+	}
+
+	ni := idemix.BigToBytes(idemix.RandModOrder(rng))
+	credRequest := idemix.NewCredRequest(sk, ni, ipk, rng)
+
+	cred, err := idemix.NewCredential(key, credRequest, attrs, rng)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed creating credential")
+	}
+
+	credBytes, err := proto.Marshal(cred)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed marshalling credential")
+	}
+
+	signerConfig := &msp.IdemixMSPSignerConfig{
+		Cred:                         credBytes,
+		Sk:                           idemix.BigToBytes(sk),
+		OrganizationalUnitIdentifier: ou,
+		Role:                         int32(role),
+		EnrollmentId:                 enrollmentId,
+		RevocationHandle:             revocationHandle,
+		RevocationPk:                 revocationPublicKey,
+	}
+
+	return proto.Marshal(signerConfig)
+}
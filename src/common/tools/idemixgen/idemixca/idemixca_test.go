@@ -0,0 +1,31 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package idemixca
+
+import "testing"
+
+func TestGenerateCRIInvalidHandle(t *testing.T) {
+	revocationKey, err := GenerateLongTermRevocationKey()
+	if err != nil {
+		t.Fatalf("failed generating revocation key: %v", err)
+	}
+
+	if _, err := GenerateCRI(revocationKey, []string{"not-a-number"}); err == nil {
+		t.Fatal("expected an error for a non-numeric revocation handle")
+	}
+}
+
+func TestGenerateCRIValidHandles(t *testing.T) {
+	revocationKey, err := GenerateLongTermRevocationKey()
+	if err != nil {
+		t.Fatalf("failed generating revocation key: %v", err)
+	}
+
+	if _, err := GenerateCRI(revocationKey, []string{"1", "2", "3"}); err != nil {
+		t.Fatalf("unexpected error for valid revocation handles: %v", err)
+	}
+}
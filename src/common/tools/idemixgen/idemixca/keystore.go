@@ -0,0 +1,144 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package idemixca
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/foglink/fnkcore/src/bccsp"
+	"github.com/foglink/fnkcore/src/bccsp/factory"
+	"github.com/foglink/fnkcore/src/bccsp/pkcs11"
+	"github.com/foglink/fnkcore/src/idemix"
+	"github.com/pkg/errors"
+)
+
+// KeystoreOpts selects where the issuer secret key is generated and stored:
+// a plain file (the default), a BCCSP software keystore, or a PKCS#11 HSM.
+type KeystoreOpts struct {
+	Type string // "file", "sw", or "pkcs11"
+
+	// Path is the on-disk directory backing a "sw" keystore. It must be
+	// recorded and reused across process invocations (ca-keygen and
+	// signerconfig run separately), or a key imported at ca-keygen time
+	// can't be found again later.
+	Path string
+
+	// Library, Pin, and Label configure a "pkcs11" keystore.
+	Library string
+	Pin     string
+	Label   string
+}
+
+// GenerateIssuerKeyBCCSP behaves like GenerateIssuerKey, except that for a
+// non-file keystore the raw secret key is not returned: it is imported into
+// the software keystore or PKCS#11 HSM and only its SKI is returned in place
+// of the raw key bytes. This mirrors how Fabric's Idemix BCCSP interfaces
+// (Issuer, IssuerSecretKey, User) decouple from the concrete idemix
+// implementation, so the CA key can be protected by an HSM.
+func GenerateIssuerKeyBCCSP(opts KeystoreOpts) ([]byte, []byte, error) {
+	if opts.Type == "" || opts.Type == "file" {
+		return GenerateIssuerKey()
+	}
+
+	rng, err := idemix.GetRand()
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed creating RNG")
+	}
+
+	key, err := idemix.NewIssuerKey(issuerAttributeNames, rng)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed creating issuer key")
+	}
+
+	ipkBytes, err := proto.Marshal(key.Ipk)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed marshalling issuer public key")
+	}
+
+	csp, err := newBCCSP(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iskKey, err := csp.KeyImport(key.Isk, &bccsp.IdemixIssuerKeyImportOpts{Temporary: false})
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed importing issuer secret key into BCCSP")
+	}
+
+	return iskKey.SKI(), ipkBytes, nil
+}
+
+// LoadIssuerSecretKeyBCCSP loads a previously-generated issuer secret key
+// back out of BCCSP by its SKI.
+//
+// For a "sw" keystore this still exports the raw key bytes, since the
+// software keystore offers no signing operations of its own and the key
+// never leaves the issuing process either way. A "pkcs11" keystore is
+// different: the whole point of putting the key in an HSM is that it never
+// leaves the token, so this refuses to export it. Issuing credentials
+// against a pkcs11-backed issuer key requires the credential-issuance path
+// itself to call through BCCSP's signing operations instead of operating on
+// raw key material, which this tool does not yet implement.
+func LoadIssuerSecretKeyBCCSP(opts KeystoreOpts, ski []byte, ipk *idemix.IssuerPublicKey) (*idemix.IssuerKey, error) {
+	if opts.Type == "pkcs11" {
+		return nil, errors.New("issuing credentials with a pkcs11-backed issuer key is not supported: " +
+			"doing so would require exporting the key from the HSM, defeating its purpose")
+	}
+
+	csp, err := newBCCSP(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := csp.GetKey(ski)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed loading issuer secret key from BCCSP")
+	}
+
+	isk, ok := key.(bccsp.IdemixIssuerSecretKey)
+	if !ok {
+		return nil, errors.Errorf("key with SKI %x is not an Idemix issuer secret key", ski)
+	}
+
+	iskBytes, err := isk.Bytes()
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed exporting issuer secret key")
+	}
+
+	return &idemix.IssuerKey{iskBytes, ipk}, nil
+}
+
+func newBCCSP(opts KeystoreOpts) (bccsp.BCCSP, error) {
+	switch opts.Type {
+	case "sw":
+		if opts.Path == "" {
+			return nil, errors.New("keystore path is required for a sw keystore")
+		}
+		if err := factory.InitFactories(&factory.FactoryOpts{
+			ProviderName: "SW",
+			SwOpts: &factory.SwOpts{
+				FileKeystore: &factory.FileKeystoreOpts{KeyStorePath: opts.Path},
+			},
+		}); err != nil {
+			return nil, errors.WithMessage(err, "failed initializing software BCCSP")
+		}
+		return factory.GetDefault(), nil
+	case "pkcs11":
+		if err := factory.InitFactories(&factory.FactoryOpts{
+			ProviderName: "PKCS11",
+			Pkcs11Opts: &pkcs11.PKCS11Opts{
+				Library: opts.Library,
+				Pin:     opts.Pin,
+				Label:   opts.Label,
+			},
+		}); err != nil {
+			return nil, errors.WithMessage(err, "failed initializing PKCS#11 BCCSP")
+		}
+		return factory.GetDefault(), nil
+	default:
+		return nil, errors.Errorf("unsupported keystore type %q", opts.Type)
+	}
+}
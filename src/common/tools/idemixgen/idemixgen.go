@@ -12,6 +12,9 @@ package main
 // the Identity Mixer MSP
 
 import (
+	"bufio"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -27,18 +30,42 @@ import (
 )
 
 const (
-	IdemixDirIssuer             = "ca"
-	IdemixConfigIssuerSecretKey = "IssuerSecretKey"
+	IdemixDirIssuer                 = "ca"
+	IdemixConfigIssuerSecretKey     = "IssuerSecretKey"
+	IdemixConfigIssuerKeystoreInfo  = "IssuerKeystoreInfo"
+	IdemixConfigIssuerRevocationKey = "IssuerRevocationKey"
+	IdemixConfigRevocationPublicKey = "IssuerRevocationPublicKey"
 )
 
 // command line flags
 var (
 	app = kingpin.New("idemixgen", "Utility for generating key material to be used with the Identity Mixer MSP in foglink fnkcore")
 
-	genIssuerKey    = app.Command("ca-keygen", "Generate CA key material")
-	genSignerConfig = app.Command("signerconfig", "Generate a default signer for this Idemix MSP")
-	genCredOU       = genSignerConfig.Flag("org-unit", "The Organizational Unit of the default signer").Short('u').String()
-	genCredIsAdmin  = genSignerConfig.Flag("admin", "Make the default signer admin").Short('a').Bool()
+	outputDir = app.Flag("output", "Directory in which to write the generated material").Short('o').Default(".").String()
+	force     = app.Flag("force", "Overwrite files that already exist in the output directory").Bool()
+	merge     = app.Flag("merge", "Only create files and directories that are missing, leaving existing CA material untouched").Bool()
+
+	keystorePath    = app.Flag("keystore-path", "Directory backing a sw keystore; must be the same across ca-keygen and signerconfig runs").String()
+	keystoreLibrary = app.Flag("keystore-library", "Path to the PKCS#11 library backing a pkcs11 keystore").String()
+	keystorePin     = app.Flag("keystore-pin", "Login PIN for a pkcs11 keystore").String()
+	keystoreLabel   = app.Flag("keystore-label", "Token label for a pkcs11 keystore").String()
+
+	genIssuerKey      = app.Command("ca-keygen", "Generate CA key material")
+	genIssuerKeystore = genIssuerKey.Flag("keystore", "Backing store for the issuer secret key").Default("file").Enum("file", "sw", "pkcs11")
+
+	genSignerConfig         = app.Command("signerconfig", "Generate a default signer for this Idemix MSP")
+	genCredOU               = genSignerConfig.Flag("org-unit", "The Organizational Unit of the default signer").Short('u').String()
+	genCredIsAdmin          = genSignerConfig.Flag("admin", "Make the default signer admin").Short('a').Bool()
+	genCredEnrollmentId     = genSignerConfig.Flag("enrollment-id", "The enrollment id of the default signer").Short('e').String()
+	genCredRevocationHandle = genSignerConfig.Flag("revocation-handle", "The revocation handle of the default signer; a random one is generated when unset").Short('r').String()
+
+	genRevocationKey = app.Command("revocation-keygen", "Generate a long-term key for the revocation authority")
+
+	genCRI           = app.Command("cri-gen", "Generate a CredentialRevocationInformation")
+	genCRIHandleFile = genCRI.Flag("revoked-handles", "Path to a file with one revocation handle per line").Short('r').String()
+
+	genNetwork     = app.Command("generate", "Bulk-generate Idemix MSP material for a network topology described in a YAML/JSON config")
+	genNetworkFile = genNetwork.Flag("config", "Path to the network topology config").Short('c').Required().String()
 
 	version = app.Command("version", "Show version information")
 )
@@ -49,33 +76,87 @@ func main() {
 	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
 
 	case genIssuerKey.FullCommand():
-		isk, ipk, err := idemixca.GenerateIssuerKey()
+		keystoreOpts := idemixca.KeystoreOpts{
+			Type:    *genIssuerKeystore,
+			Path:    *keystorePath,
+			Library: *keystoreLibrary,
+			Pin:     *keystorePin,
+			Label:   *keystoreLabel,
+		}
+
+		// check for existing CA material before generating (and, for sw/pkcs11,
+		// importing into the keystore/HSM) a new issuer key: a rejected
+		// invocation must not leave an orphaned key behind in persistent storage
+		caDir := outPath(IdemixDirIssuer)
+		issuerKeyExists := dirExists(outPath(IdemixDirIssuer, IdemixConfigIssuerSecretKey)) ||
+			dirExists(outPath(IdemixDirIssuer, IdemixConfigIssuerKeystoreInfo))
+		if dirExists(caDir) && !*force && !*merge {
+			handleError(errors.Errorf("directory %s already exists; use --force or --merge to proceed", caDir))
+		}
+
+		ensureDir(caDir)
+		ensureDir(outPath(msp.IdemixConfigDirMsp))
+
+		if issuerKeyExists && *merge {
+			// --merge leaves an already-initialized CA's issuer key untouched,
+			// so don't generate (and, for sw/pkcs11, import) a new one just to
+			// discard it
+			break
+		}
+
+		isk, ipk, err := idemixca.GenerateIssuerKeyBCCSP(keystoreOpts)
+		handleError(err)
+
+		// for a file keystore isk is the raw secret key; for sw/pkcs11 it is
+		// only the key's SKI, so record where to find it instead of the key
+		// material itself
+		if keystoreOpts.Type == "" || keystoreOpts.Type == "file" {
+			writeFile(outPath(IdemixDirIssuer, IdemixConfigIssuerSecretKey), isk)
+		} else {
+			handleError(writeKeystoreInfo(outPath(IdemixDirIssuer, IdemixConfigIssuerKeystoreInfo), keystoreOpts, isk))
+		}
+		writeFile(outPath(IdemixDirIssuer, msp.IdemixConfigFileIssuerPublicKey), ipk)
+		writeFile(outPath(msp.IdemixConfigDirMsp, msp.IdemixConfigFileIssuerPublicKey), ipk)
+
+		// also generate the long-term revocation key, so a fresh CA always has
+		// one available
+		_, err = generateRevocationKey(*outputDir)
 		handleError(err)
 
-		// Prevent overwriting the existing key
-		path := filepath.Join(IdemixDirIssuer)
-		checkDirectoryNotExists(path, fmt.Sprintf("Directory %s already exists", path))
+	case genRevocationKey.FullCommand():
+		_, err := generateRevocationKey(*outputDir)
+		handleError(err)
+
+	case genCRI.FullCommand():
+		revocationKey := readRevocationKey()
+
+		handles, err := readRevokedHandles(*genCRIHandleFile)
+		handleError(err)
 
-		path = msp.IdemixConfigDirMsp
-		checkDirectoryNotExists(path, fmt.Sprintf("Directory %s already exists", path))
+		cri, err := idemixca.GenerateCRI(revocationKey, handles)
+		handleError(err)
 
-		// write private and public keys to the file
-		handleError(os.Mkdir(IdemixDirIssuer, 0770))
-		handleError(os.Mkdir(msp.IdemixConfigDirMsp, 0770))
-		writeFile(filepath.Join(IdemixDirIssuer, IdemixConfigIssuerSecretKey), isk)
-		writeFile(filepath.Join(IdemixDirIssuer, msp.IdemixConfigFileIssuerPublicKey), ipk)
-		writeFile(filepath.Join(msp.IdemixConfigDirMsp, msp.IdemixConfigFileIssuerPublicKey), ipk)
+		writeFile(outPath(IdemixDirIssuer, msp.IdemixConfigFileRevocation), cri)
+		writeFile(outPath(msp.IdemixConfigDirMsp, msp.IdemixConfigFileRevocation), cri)
 
 	case genSignerConfig.FullCommand():
-		config, err := idemixca.GenerateSignerConfig(*genCredIsAdmin, *genCredOU, readIssuerKey())
+		revocationHandle := *genCredRevocationHandle
+		if revocationHandle == "" {
+			var err error
+			revocationHandle, err = idemixca.GenerateRevocationHandle()
+			handleError(err)
+		}
+
+		config, err := idemixca.GenerateSignerConfig(*genCredIsAdmin, *genCredOU, *genCredEnrollmentId, revocationHandle, readIssuerKey(), readRevocationPublicKeyPEM())
 		handleError(err)
 
-		path := msp.IdemixConfigDirUser
-		checkDirectoryNotExists(path, fmt.Sprintf("This MSP config already contains a directory \"%s\"", path))
+		ensureDir(outPath(msp.IdemixConfigDirUser))
+		writeFile(outPath(msp.IdemixConfigDirUser, msp.IdemixConfigFileSigner), config)
 
-		// Write config to file
-		handleError(os.Mkdir(msp.IdemixConfigDirUser, 0770))
-		writeFile(filepath.Join(msp.IdemixConfigDirUser, msp.IdemixConfigFileSigner), config)
+	case genNetwork.FullCommand():
+		spec, err := parseNetworkSpec(*genNetworkFile)
+		handleError(err)
+		handleError(generateNetwork(spec, *outputDir))
 
 	case version.FullCommand():
 		printVersion()
@@ -86,36 +167,170 @@ func printVersion() {
 	fmt.Println(metadata.GetVersionInfo())
 }
 
-// writeFile writes bytes to a file and panics in case of an error
+// outPath resolves elems relative to the configured --output directory
+func outPath(elems ...string) string {
+	return filepath.Join(append([]string{*outputDir}, elems...)...)
+}
+
+// dirExists reports whether path already exists.
+func dirExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// ensureDir makes sure path exists, honoring --force and --merge: by default
+// it refuses to touch a directory that is already there, --merge leaves
+// existing material as-is, and --force lets it be written over.
+func ensureDir(path string) {
+	if _, err := os.Stat(path); err == nil {
+		if *force || *merge {
+			return
+		}
+		handleError(errors.Errorf("directory %s already exists; use --force or --merge to proceed", path))
+	}
+	handleError(os.MkdirAll(path, 0770))
+}
+
+// writeFile writes bytes to a file, honoring --force and --merge, and exits
+// the process in case of an error
 func writeFile(path string, contents []byte) {
+	if _, err := os.Stat(path); err == nil {
+		if *merge {
+			return
+		}
+		if !*force {
+			handleError(errors.Errorf("file %s already exists; use --force or --merge to proceed", path))
+		}
+	}
 	handleError(ioutil.WriteFile(path, contents, 0640))
 }
 
-// readIssuerKey reads the issuer key from the current directory
+// readIssuerKey reads the issuer key from the output directory. The secret
+// key itself may live in a raw file (the default) or, when ca-keygen was run
+// with --keystore sw/pkcs11, in BCCSP. A sw-backed key is loaded by SKI; a
+// pkcs11-backed key is not loaded, and this exits with an error instead.
 func readIssuerKey() *idemix.IssuerKey {
-	path := filepath.Join(IdemixDirIssuer, IdemixConfigIssuerSecretKey)
-	isk, err := ioutil.ReadFile(path)
-	if err != nil {
-		handleError(errors.Wrapf(err, "failed to open issuer secret key file: %s", path))
-	}
-	path = filepath.Join(IdemixDirIssuer, msp.IdemixConfigFileIssuerPublicKey)
+	path := outPath(IdemixDirIssuer, msp.IdemixConfigFileIssuerPublicKey)
 	ipkBytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		handleError(errors.Wrapf(err, "failed to open issuer public key file: %s", path))
 	}
 	ipk := &idemix.IssuerPublicKey{}
 	handleError(proto.Unmarshal(ipkBytes, ipk))
-	key := &idemix.IssuerKey{isk, ipk}
+
+	info, ski, err := readKeystoreInfo(outPath(IdemixDirIssuer, IdemixConfigIssuerKeystoreInfo))
+	handleError(err)
+	if info == nil {
+		path := outPath(IdemixDirIssuer, IdemixConfigIssuerSecretKey)
+		isk, err := ioutil.ReadFile(path)
+		if err != nil {
+			handleError(errors.Wrapf(err, "failed to open issuer secret key file: %s", path))
+		}
+		return &idemix.IssuerKey{isk, ipk}
+	}
+
+	key, err := idemixca.LoadIssuerSecretKeyBCCSP(idemixca.KeystoreOpts{
+		Type:    info.Type,
+		Path:    firstNonEmpty(info.Path, *keystorePath),
+		Library: firstNonEmpty(info.Library, *keystoreLibrary),
+		Pin:     *keystorePin,
+		Label:   firstNonEmpty(info.Label, *keystoreLabel),
+	}, ski, ipk)
+	handleError(err)
 
 	return key
 }
 
-// checkDirectoryNotExists checks whether a directory with the given path already exists and exits if this is the case
-func checkDirectoryNotExists(path string, errorMessage string) {
-	_, err := os.Stat(path)
-	if err == nil {
-		handleError(errors.New(errorMessage))
+// generateRevocationKey creates the long-term revocation key and writes the
+// private key and the PEM-encoded public key to dir/<IdemixDirIssuer> and
+// into dir/<msp.IdemixConfigDirMsp>, returning the PEM-encoded public key.
+// Used both for the top-level output directory and, by the generate
+// subcommand, per-organization directories.
+func generateRevocationKey(dir string) ([]byte, error) {
+	key, err := idemixca.GenerateLongTermRevocationKey()
+	if err != nil {
+		return nil, err
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&key.PrivateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pubKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKeyBytes})
+
+	// the CA and MSP directories are expected to already exist; create them
+	// if not so that revocation-keygen also works standalone
+	if err := os.MkdirAll(filepath.Join(dir, IdemixDirIssuer), 0770); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, msp.IdemixConfigDirMsp), 0770); err != nil {
+		return nil, err
+	}
+	writeFile(filepath.Join(dir, IdemixDirIssuer, IdemixConfigIssuerRevocationKey), keyBytes)
+	writeFile(filepath.Join(dir, IdemixDirIssuer, IdemixConfigRevocationPublicKey), pubKeyPEM)
+	writeFile(filepath.Join(dir, msp.IdemixConfigDirMsp, msp.IdemixConfigFileRevocationPublicKey), pubKeyPEM)
+
+	return pubKeyPEM, nil
+}
+
+// readRevocationKey reads the long-term revocation key from the CA directory.
+func readRevocationKey() *idemix.RevocationKey {
+	path := outPath(IdemixDirIssuer, IdemixConfigIssuerRevocationKey)
+	keyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		handleError(errors.Wrapf(err, "failed to open issuer revocation key file: %s", path))
+	}
+
+	privKey, err := x509.ParseECPrivateKey(keyBytes)
+	if err != nil {
+		handleError(errors.Wrapf(err, "failed to parse issuer revocation key file: %s", path))
 	}
+
+	return &idemix.RevocationKey{PrivateKey: privKey}
+}
+
+// readRevocationPublicKeyPEM reads the PEM-encoded revocation public key
+// from the CA directory, to be embedded into signer configs.
+func readRevocationPublicKeyPEM() []byte {
+	path := outPath(IdemixDirIssuer, IdemixConfigRevocationPublicKey)
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		handleError(errors.Wrapf(err, "failed to open issuer revocation public key file: %s", path))
+	}
+	return pemBytes
+}
+
+// readRevokedHandles reads a list of revoked revocation handles from path, one per line.
+func readRevokedHandles(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open revoked handles file: %s", path)
+	}
+	defer f.Close()
+
+	var handles []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		handles = append(handles, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read revoked handles file: %s", path)
+	}
+
+	return handles, nil
 }
 
 func handleError(err error) {
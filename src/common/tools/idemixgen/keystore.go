@@ -0,0 +1,82 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/foglink/fnkcore/src/common/tools/idemixgen/idemixca"
+	"github.com/pkg/errors"
+)
+
+// keystoreInfo is persisted alongside the CA material in place of the raw
+// issuer secret key when that key lives in BCCSP instead of a file; it
+// records just enough to find the key again at signerconfig time. The PIN is
+// intentionally never persisted.
+type keystoreInfo struct {
+	Type    string `json:"type"`
+	SKI     string `json:"ski"`
+	Path    string `json:"path,omitempty"`
+	Library string `json:"library,omitempty"`
+	Label   string `json:"label,omitempty"`
+}
+
+// writeKeystoreInfo records where a BCCSP-backed issuer secret key was stored.
+func writeKeystoreInfo(path string, opts idemixca.KeystoreOpts, ski []byte) error {
+	info := keystoreInfo{
+		Type:    opts.Type,
+		SKI:     hex.EncodeToString(ski),
+		Path:    opts.Path,
+		Library: opts.Library,
+		Label:   opts.Label,
+	}
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return errors.WithMessage(err, "failed marshalling keystore info")
+	}
+
+	writeFile(path, raw)
+	return nil
+}
+
+// readKeystoreInfo reads keystore metadata for a BCCSP-backed issuer secret
+// key, or returns (nil, nil) if the issuer secret key is a plain file.
+func readKeystoreInfo(path string) (*keystoreInfo, []byte, error) {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to open issuer keystore info file: %s", path)
+	}
+
+	info := &keystoreInfo{}
+	if err := json.Unmarshal(raw, info); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to parse issuer keystore info file: %s", path)
+	}
+
+	ski, err := hex.DecodeString(info.SKI)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid SKI in issuer keystore info file: %s", path)
+	}
+
+	return info, ski, nil
+}
+
+// firstNonEmpty returns the first non-empty string among vals.
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
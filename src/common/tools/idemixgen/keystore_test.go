@@ -0,0 +1,66 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/foglink/fnkcore/src/common/tools/idemixgen/idemixca"
+)
+
+func TestKeystoreInfoRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idemixgen-keystore-test")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, IdemixConfigIssuerKeystoreInfo)
+	opts := idemixca.KeystoreOpts{
+		Type: "sw",
+		Path: "/var/lib/idemix/keystore",
+	}
+	ski := []byte{0x01, 0x02, 0x03}
+
+	if err := writeKeystoreInfo(path, opts, ski); err != nil {
+		t.Fatalf("writeKeystoreInfo failed: %v", err)
+	}
+
+	info, gotSKI, err := readKeystoreInfo(path)
+	if err != nil {
+		t.Fatalf("readKeystoreInfo failed: %v", err)
+	}
+	if info == nil {
+		t.Fatal("expected non-nil keystore info")
+	}
+	if info.Type != opts.Type || info.Path != opts.Path {
+		t.Errorf("round-tripped info = %+v, want Type=%s Path=%s", info, opts.Type, opts.Path)
+	}
+	if hex.EncodeToString(gotSKI) != hex.EncodeToString(ski) {
+		t.Errorf("round-tripped SKI = %x, want %x", gotSKI, ski)
+	}
+}
+
+func TestReadKeystoreInfoMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idemixgen-keystore-test")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	info, ski, err := readKeystoreInfo(filepath.Join(dir, "does-not-exist"))
+	if err != nil {
+		t.Fatalf("unexpected error for a missing file: %v", err)
+	}
+	if info != nil || ski != nil {
+		t.Fatalf("expected (nil, nil) for a missing file, got (%+v, %x)", info, ski)
+	}
+}
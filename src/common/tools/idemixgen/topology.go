@@ -0,0 +1,137 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/foglink/fnkcore/src/common/tools/idemixgen/idemixca"
+	"github.com/foglink/fnkcore/src/idemix"
+	"github.com/foglink/fnkcore/src/msp"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// NetworkSpec describes the Idemix MSP material to generate for a set of
+// organizations in one invocation, analogous to cryptogen's crypto-config.yaml.
+type NetworkSpec struct {
+	Organizations []OrgSpec `yaml:"organizations"`
+}
+
+// OrgSpec describes one organization's issuer and the admins/members to
+// enroll against it. EnrollmentIDs, when given, assigns the enrollment id
+// for each signer in turn (admins first, then members); any signer beyond
+// the end of the list falls back to a generated id.
+type OrgSpec struct {
+	Name          string   `yaml:"name"`
+	OU            string   `yaml:"orgUnit"`
+	Admins        int      `yaml:"admins"`
+	Members       int      `yaml:"members"`
+	EnrollmentIDs []string `yaml:"enrollmentIds"`
+}
+
+// parseNetworkSpec reads and parses a network topology config from path.
+func parseNetworkSpec(path string) (*NetworkSpec, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read network config: %s", path)
+	}
+
+	spec := &NetworkSpec{}
+	if err := yaml.UnmarshalStrict(raw, spec); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse network config: %s", path)
+	}
+
+	return spec, nil
+}
+
+// generateNetwork produces a full directory tree of Idemix issuer material
+// and signer configs for every organization in spec, rooted at outputDir.
+// Each organization gets its own CA and verifier MSP, plus a subdirectory
+// per admin/member signer, so integration tests can bootstrap an entire
+// network from a single config file.
+func generateNetwork(spec *NetworkSpec, outputDir string) error {
+	for _, org := range spec.Organizations {
+		if err := generateOrg(org, filepath.Join(outputDir, org.Name)); err != nil {
+			return errors.Wrapf(err, "failed generating material for organization %s", org.Name)
+		}
+	}
+	return nil
+}
+
+func generateOrg(org OrgSpec, orgDir string) error {
+	isk, ipkBytes, err := idemixca.GenerateIssuerKey()
+	if err != nil {
+		return errors.WithMessage(err, "failed generating issuer key")
+	}
+	ipk := &idemix.IssuerPublicKey{}
+	if err := proto.Unmarshal(ipkBytes, ipk); err != nil {
+		return errors.WithMessage(err, "failed unmarshalling issuer public key")
+	}
+	issuerKey := &idemix.IssuerKey{isk, ipk}
+
+	ensureDir(filepath.Join(orgDir, IdemixDirIssuer))
+	ensureDir(filepath.Join(orgDir, msp.IdemixConfigDirMsp))
+	writeFile(filepath.Join(orgDir, IdemixDirIssuer, IdemixConfigIssuerSecretKey), isk)
+	writeFile(filepath.Join(orgDir, IdemixDirIssuer, msp.IdemixConfigFileIssuerPublicKey), ipkBytes)
+	writeFile(filepath.Join(orgDir, msp.IdemixConfigDirMsp, msp.IdemixConfigFileIssuerPublicKey), ipkBytes)
+
+	revocationPubKeyPEM, err := generateRevocationKey(orgDir)
+	if err != nil {
+		return errors.WithMessage(err, "failed generating revocation key")
+	}
+
+	for i := 0; i < org.Admins; i++ {
+		name := fmt.Sprintf("Admin%d", i)
+		enrollmentId := enrollmentIDFor(org, i, name)
+		if err := generateOrgSigner(issuerKey, revocationPubKeyPEM, enrollmentId, org.OU, orgDir, name, true); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < org.Members; i++ {
+		name := fmt.Sprintf("User%d", i)
+		enrollmentId := enrollmentIDFor(org, org.Admins+i, name)
+		if err := generateOrgSigner(issuerKey, revocationPubKeyPEM, enrollmentId, org.OU, orgDir, name, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enrollmentIDFor returns the operator-supplied enrollment id at position idx
+// in org.EnrollmentIDs, falling back to a generated id when the config
+// doesn't list enough of them.
+func enrollmentIDFor(org OrgSpec, idx int, defaultName string) string {
+	if idx < len(org.EnrollmentIDs) {
+		return org.EnrollmentIDs[idx]
+	}
+	return fmt.Sprintf("%s.%s", defaultName, org.Name)
+}
+
+// generateOrgSigner issues a signer config for name within org and writes it
+// to orgDir/name/<msp.IdemixConfigDirUser>.
+func generateOrgSigner(issuerKey *idemix.IssuerKey, revocationPublicKey []byte, enrollmentId, ou, orgDir, name string, isAdmin bool) error {
+	revocationHandle, err := idemixca.GenerateRevocationHandle()
+	if err != nil {
+		return errors.WithMessage(err, "failed generating revocation handle")
+	}
+
+	config, err := idemixca.GenerateSignerConfig(isAdmin, ou, enrollmentId, revocationHandle, issuerKey, revocationPublicKey)
+	if err != nil {
+		return errors.Wrapf(err, "failed generating signer config for %s", enrollmentId)
+	}
+
+	signerDir := filepath.Join(orgDir, name, msp.IdemixConfigDirUser)
+	ensureDir(signerDir)
+	writeFile(filepath.Join(signerDir, msp.IdemixConfigFileSigner), config)
+
+	return nil
+}
@@ -0,0 +1,40 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import "testing"
+
+func TestEnrollmentIDFor(t *testing.T) {
+	org := OrgSpec{
+		Name:          "org1",
+		EnrollmentIDs: []string{"alice", "bob"},
+	}
+
+	tests := []struct {
+		idx      int
+		name     string
+		expected string
+	}{
+		{0, "Admin0", "alice"},
+		{1, "Admin1", "bob"},
+		{2, "User0", "User0.org1"},
+	}
+
+	for _, tt := range tests {
+		if got := enrollmentIDFor(org, tt.idx, tt.name); got != tt.expected {
+			t.Errorf("enrollmentIDFor(org, %d, %q) = %q, want %q", tt.idx, tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestEnrollmentIDForNoConfiguredIDs(t *testing.T) {
+	org := OrgSpec{Name: "org1"}
+
+	if got, want := enrollmentIDFor(org, 0, "Admin0"), "Admin0.org1"; got != want {
+		t.Errorf("enrollmentIDFor(org, 0, %q) = %q, want %q", "Admin0", got, want)
+	}
+}